@@ -2,14 +2,17 @@ package chclient
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"github.com/valyala/tsvreader"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,16 +22,50 @@ import (
 //
 // Best used with github.com/Vertamedia/chproxy.
 type Client struct {
-	// Addr is clickhouse address to connect to.
+	// Addrs is a list of clickhouse addresses to connect to.
 	//
-	// localhost:8123 is used by default.
-	Addr string
+	// []string{"localhost:8123"} is used by default.
+	//
+	// When more than one address is given, Client balances requests
+	// across them according to HostPolicy. A host that fails MaxFailures
+	// times in a row is tried only after all healthy hosts have failed,
+	// until UnhealthyCooldown elapses.
+	Addrs []string
+
+	// HostPolicy selects the order in which healthy hosts from Addrs
+	// are tried.
+	//
+	// RoundRobin is used by default.
+	HostPolicy HostPolicy
 
-	// FallbackAddr is a fallback clickhouse address that is used
-	// if request to Addr fails.
+	// MaxFailures is the number of consecutive failed requests to a host
+	// after which the host is considered unhealthy and excluded from
+	// HostPolicy selection for UnhealthyCooldown.
 	//
-	// By default there is no fallback address.
-	FallbackAddr string
+	// DefaultMaxFailures is used by default.
+	MaxFailures int
+
+	// UnhealthyCooldown is how long a host stays excluded from HostPolicy
+	// selection after being marked unhealthy.
+	//
+	// DefaultUnhealthyCooldown is used by default.
+	UnhealthyCooldown time.Duration
+
+	// OnHostFailure, if set, is called after every failed request to addr,
+	// including requests to hosts already marked unhealthy. Failures caused
+	// by ctx being canceled or its deadline being exceeded are not reported.
+	OnHostFailure func(addr string, err error)
+
+	// OnHostRecover, if set, is called when a successful request is served
+	// by a host that was previously marked unhealthy.
+	OnHostRecover func(addr string)
+
+	// KillQueryTimeout bounds how long Client waits for the out-of-band
+	// `KILL QUERY` it issues on the same host when ctx is canceled or its
+	// deadline is exceeded mid-query.
+	//
+	// DefaultKillQueryTimeout is used by default.
+	KillQueryTimeout time.Duration
 
 	// User to use when connecting to clickhouse.
 	//
@@ -57,6 +94,25 @@ type Client struct {
 	// Response compression is disabled by default.
 	CompressResponse bool
 
+	// Whether to gzip-compress the request body sent to clickhouse.
+	//
+	// This is useful when sending large INSERT queries, since it reduces
+	// the amount of data transferred over the network at the cost of CPU
+	// time spent on compression.
+	//
+	// For queries, only request bodies exceeding CompressRequestThreshold
+	// are compressed. Insert compresses its streamed body whenever
+	// CompressRequest is set, regardless of CompressRequestThreshold.
+	//
+	// Request compression is disabled by default.
+	CompressRequest bool
+
+	// CompressRequestThreshold is the size, in bytes, that a query's request
+	// body must exceed for it to be gzip-compressed when CompressRequest is set.
+	//
+	// DefaultCompressRequestThreshold is used if not set.
+	CompressRequestThreshold int
+
 	// Timeout is the maximum duration for the query.
 	//
 	// DefaultTimeout is used by default.
@@ -71,11 +127,43 @@ type Client struct {
 	//         "no_cache=1",
 	//     }
 	URLParams []string
+
+	// DefaultFormat is the Format used by DoFormat and Insert when their
+	// format argument is nil.
+	//
+	// TabSeparated is used by default.
+	DefaultFormat Format
+
+	// FlushRows is the number of rows an InsertWriter buffers before
+	// flushing them to clickhouse.
+	//
+	// DefaultFlushRows is used by default.
+	FlushRows int
+
+	// FlushBytes is the number of bytes an InsertWriter buffers before
+	// flushing them to clickhouse, regardless of FlushRows.
+	//
+	// DefaultFlushBytes is used by default.
+	FlushBytes int
+
+	// FlushInterval is the maximum duration rows may sit buffered in an
+	// InsertWriter before being flushed to clickhouse, regardless of
+	// FlushRows and FlushBytes.
+	//
+	// DefaultFlushInterval is used by default.
+	FlushInterval time.Duration
+
+	poolOnce  sync.Once
+	poolState *hostPool
 }
 
 // DefaultTimeout is the default timeout for Client.
 var DefaultTimeout = 30 * time.Second
 
+// DefaultCompressRequestThreshold is the default value for
+// Client.CompressRequestThreshold.
+var DefaultCompressRequestThreshold = 512
+
 // ReadRowsFunc must read rows from r.
 type ReadRowsFunc func(r *tsvreader.Reader) error
 
@@ -102,43 +190,175 @@ func (c *Client) Do(query string, f ReadRowsFunc) error {
 // The maximum query duration may be limited with the ctx.
 //
 // f may be nil if query result isn't needed.
+//
+// If Client.Addrs contains more than one address, DoContext tries healthy
+// hosts in the order chosen by Client.HostPolicy, then hosts marked
+// unhealthy, and only returns an error after all of them have failed or
+// ctx is done.
+//
+// The query_id sent to clickhouse is taken from ctx (see WithQueryID) if
+// present, or auto-generated otherwise. Use DoContextWithID to control it
+// explicitly or to read it back.
 func (c *Client) DoContext(ctx context.Context, query string, f ReadRowsFunc) error {
-	addr := c.addr()
-	resp, err := c.doRequest(ctx, addr, query)
-	if err != nil {
-		// Try requesting fallback address.
-		addr = c.FallbackAddr
-		if len(addr) == 0 {
-			// There is no fallback address. Just return the error.
-			return err
-		}
-		resp2, err2 := c.doRequest(ctx, addr, query)
-		if err2 != nil {
-			return fmt.Errorf("cannot request neither primary nor fallback address: %q and %q", err, err2)
+	queryID, _ := queryIDFromContext(ctx)
+	qp := queryParams{queryID: queryID, format: TabSeparated}
+	_, err := c.doQuery(ctx, query, qp, readRowsFuncToRowReaderFunc(f))
+	return err
+}
+
+// DoContextWithID behaves like DoContext, but additionally sends the given
+// query_id and quota_key to clickhouse. If queryID is empty, one is taken
+// from ctx (see WithQueryID) or auto-generated. The query_id actually used
+// is returned alongside the error so that it can be correlated with
+// system.query_log.
+//
+// If ctx is canceled or its deadline is exceeded while the query is in
+// flight, DoContextWithID fires an out-of-band `KILL QUERY` for queryID at
+// the same host, bounded by Client.KillQueryTimeout, so clickhouse stops
+// burning CPU on a query nobody is waiting for anymore.
+//
+// f may be nil if query result isn't needed.
+func (c *Client) DoContextWithID(ctx context.Context, queryID, quotaKey, query string, f ReadRowsFunc) (string, error) {
+	if queryID == "" {
+		queryID, _ = queryIDFromContext(ctx)
+	}
+	qp := queryParams{queryID: queryID, quotaKey: quotaKey, format: TabSeparated}
+	return c.doQuery(ctx, query, qp, readRowsFuncToRowReaderFunc(f))
+}
+
+// RowReaderFunc must read rows from r, which has the concrete type
+// returned by the Format.NewReader passed to DoFormat.
+type RowReaderFunc func(r RowReader) error
+
+// DoFormat behaves like DoContext, but requests results in the given
+// format instead of plain TabSeparated. This makes it possible to query
+// nested/array/IPv4/IPv6 columns, which are awkward to parse from
+// TabSeparated, in a format that preserves their types.
+//
+// format may be nil, in which case Client.DefaultFormat is used.
+//
+// f may be nil if query result isn't needed.
+func (c *Client) DoFormat(ctx context.Context, query string, format Format, f RowReaderFunc) error {
+	if format == nil {
+		format = c.defaultFormat()
+	}
+	queryID, _ := queryIDFromContext(ctx)
+	qp := queryParams{queryID: queryID, format: format}
+	_, err := c.doQuery(ctx, query, qp, f)
+	return err
+}
+
+// queryParams bundles the per-request values that get threaded through
+// prepareRequest, in addition to the query text itself.
+type queryParams struct {
+	queryID   string
+	quotaKey  string
+	sessionID string
+	format    Format
+
+	// paramArgs holds pre-rendered `param_<name>=<value>` URL arguments
+	// for DoParams-style server-side bound parameters.
+	paramArgs []string
+}
+
+func (c *Client) doQuery(ctx context.Context, query string, qp queryParams, f RowReaderFunc) (string, error) {
+	if qp.queryID == "" {
+		qp.queryID = newQueryID()
+	}
+
+	hosts := c.pool().order(c.hostPolicy())
+
+	var resp *http.Response
+	var addr string
+	var errs []string
+	for _, h := range hosts {
+		resp2, err := c.doRequestHost(ctx, h, query, qp)
+		if err != nil {
+			if ctx.Err() != nil {
+				// ctx was canceled or its deadline was exceeded: don't
+				// keep trying the remaining hosts, since none of them
+				// ran the query either and each would fire its own
+				// spurious out-of-band KILL QUERY.
+				return qp.queryID, ctx.Err()
+			}
+			errs = append(errs, err.Error())
+			continue
 		}
 		resp = resp2
+		addr = h.addr
+		break
+	}
+	if resp == nil {
+		return qp.queryID, fmt.Errorf("cannot request any of %d clickhouse host(s) for query %q (query_id=%s): %s",
+			len(hosts), query, qp.queryID, strings.Join(errs, "; "))
 	}
 	defer resp.Body.Close()
 
 	if f == nil {
-		return nil
+		return qp.queryID, nil
 	}
 
 	ct := resp.Header.Get("Content-Type")
-	if !strings.HasPrefix(ct, "text/tab-separated-values") {
-		return fmt.Errorf("unexpected Content-Type for query %q sent to %q: %q. Expecting %q",
-			query, addr, ct, "text/tab-separated-values")
+	if !strings.HasPrefix(ct, qp.format.ContentType()) {
+		return qp.queryID, fmt.Errorf("unexpected Content-Type for query %q (query_id=%s) sent to %q: %q. Expecting %q",
+			query, qp.queryID, addr, ct, qp.format.ContentType())
 	}
 
-	r := tsvreader.New(resp.Body)
-	if err := f(r); err != nil {
-		return err
+	r := qp.format.NewReader(resp.Body)
+	err := f(r)
+	if err == nil {
+		err = r.Error()
 	}
-	return r.Error()
+	if err != nil && ctx.Err() != nil {
+		// ctx ended while the response was still being read, so clickhouse
+		// may still be running the query on addr.
+		c.killQuery(addr, qp.queryID)
+	}
+	return qp.queryID, err
 }
 
-func (c *Client) doRequest(ctx context.Context, addr, query string) (*http.Response, error) {
-	req := c.prepareRequest(addr, query)
+func readRowsFuncToRowReaderFunc(f ReadRowsFunc) RowReaderFunc {
+	if f == nil {
+		return nil
+	}
+	return func(r RowReader) error {
+		return f(r.(*tsvreader.Reader))
+	}
+}
+
+// doRequestHost performs the request against h, updating its health state
+// and firing OnHostFailure / OnHostRecover as appropriate.
+func (c *Client) doRequestHost(ctx context.Context, h *hostState, query string, qp queryParams) (*http.Response, error) {
+	h.addInflight(1)
+	defer h.addInflight(-1)
+
+	resp, err := c.doRequest(ctx, h.addr, query, qp)
+	if err != nil {
+		if ctx.Err() != nil {
+			// ctx was canceled or its deadline was exceeded: this isn't
+			// h's fault, so don't count it against its health. clickhouse
+			// is still running the query on h even though we gave up on
+			// it, so fire an out-of-band KILL QUERY for it.
+			c.killQuery(h.addr, qp.queryID)
+			return nil, err
+		}
+		h.recordFailure(c.maxFailures(), c.unhealthyCooldown())
+		if c.OnHostFailure != nil {
+			c.OnHostFailure(h.addr, err)
+		}
+		return nil, err
+	}
+	if h.recordSuccess() && c.OnHostRecover != nil {
+		c.OnHostRecover(h.addr)
+	}
+	return resp, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, addr, query string, qp queryParams) (*http.Response, error) {
+	req, err := c.prepareRequest(addr, query, qp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot prepare request for query %q to %q: %s", query, addr, err)
+	}
 	req = req.WithContext(ctx)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -155,13 +375,13 @@ func (c *Client) doRequest(ctx context.Context, addr, query string) (*http.Respo
 	return resp, nil
 }
 
-func (c *Client) prepareRequest(addr, query string) *http.Request {
+func (c *Client) prepareRequest(addr, query string, qp queryParams) (*http.Request, error) {
 	scheme := "http"
 	if c.UseHTTPS {
 		scheme = "https"
 	}
 
-	args := make([]string, 0, len(c.URLParams)+4)
+	args := make([]string, 0, len(c.URLParams)+len(qp.paramArgs)+6)
 	for _, p := range c.URLParams {
 		args = append(args, p)
 	}
@@ -173,16 +393,41 @@ func (c *Client) prepareRequest(addr, query string) *http.Request {
 	if c.Database != "" {
 		args = append(args, fmt.Sprintf("database=%s", url.QueryEscape(c.Database)))
 	}
-	if c.CompressResponse {
+	if qp.queryID != "" {
+		args = append(args, fmt.Sprintf("query_id=%s", url.QueryEscape(qp.queryID)))
+	}
+	if qp.quotaKey != "" {
+		args = append(args, fmt.Sprintf("quota_key=%s", url.QueryEscape(qp.quotaKey)))
+	}
+	if qp.sessionID != "" {
+		args = append(args, fmt.Sprintf("session_id=%s", url.QueryEscape(qp.sessionID)))
+	}
+	if qp.format != nil && qp.format != TabSeparated {
+		args = append(args, fmt.Sprintf("default_format=%s", url.QueryEscape(qp.format.URLParam())))
+	}
+	args = append(args, qp.paramArgs...)
+	compressBody := c.CompressRequest && len(query) > c.compressRequestThreshold()
+	if c.CompressResponse || compressBody {
 		args = append(args, "enable_http_compression=1")
 	}
 	xurl := fmt.Sprintf("%s://%s/?%s", scheme, addr, strings.Join(args, "&"))
 
-	body := bytes.NewBufferString(query)
+	var body io.Reader = bytes.NewBufferString(query)
+	if compressBody {
+		buf, err := gzipCompress(query)
+		if err != nil {
+			return nil, fmt.Errorf("cannot gzip-compress query %q: %s", query, err)
+		}
+		body = buf
+	}
+
 	req, err := http.NewRequest("POST", xurl, body)
 	if err != nil {
 		panic(fmt.Sprintf("BUG: cannot create request from xurl=%q, query=%q", xurl, query))
 	}
+	if compressBody {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	if !c.CompressResponse {
 		// Explicitly disable response compression if it isn't enabled,
 		// since net/http client by default transparently enables
@@ -191,14 +436,48 @@ func (c *Client) prepareRequest(addr, query string) *http.Request {
 		req.Header.Set("Accept-Encoding", "identity")
 	}
 
-	return req
+	return req, nil
+}
+
+func gzipCompress(s string) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+func (c *Client) pool() *hostPool {
+	c.poolOnce.Do(func() {
+		addrs := c.Addrs
+		if len(addrs) == 0 {
+			addrs = []string{"localhost:8123"}
+		}
+		c.poolState = newHostPool(addrs)
+	})
+	return c.poolState
+}
+
+func (c *Client) hostPolicy() HostPolicy {
+	return c.HostPolicy
 }
 
-func (c *Client) addr() string {
-	if c.Addr == "" {
-		return "localhost:8123"
+func (c *Client) maxFailures() int {
+	if c.MaxFailures <= 0 {
+		return DefaultMaxFailures
 	}
-	return c.Addr
+	return c.MaxFailures
+}
+
+func (c *Client) unhealthyCooldown() time.Duration {
+	if c.UnhealthyCooldown <= 0 {
+		return DefaultUnhealthyCooldown
+	}
+	return c.UnhealthyCooldown
 }
 
 func (c *Client) user() string {
@@ -214,3 +493,24 @@ func (c *Client) timeout() time.Duration {
 	}
 	return c.Timeout
 }
+
+func (c *Client) compressRequestThreshold() int {
+	if c.CompressRequestThreshold <= 0 {
+		return DefaultCompressRequestThreshold
+	}
+	return c.CompressRequestThreshold
+}
+
+func (c *Client) defaultFormat() Format {
+	if c.DefaultFormat == nil {
+		return TabSeparated
+	}
+	return c.DefaultFormat
+}
+
+func (c *Client) killQueryTimeout() time.Duration {
+	if c.KillQueryTimeout <= 0 {
+		return DefaultKillQueryTimeout
+	}
+	return c.KillQueryTimeout
+}
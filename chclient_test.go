@@ -1,8 +1,11 @@
 package chclient
 
 import (
+	"compress/gzip"
+	"context"
 	"fmt"
 	"github.com/valyala/tsvreader"
+	"io/ioutil"
 	"testing"
 	"time"
 )
@@ -25,6 +28,36 @@ func TestClientDoWithCompression(t *testing.T) {
 	testClientDo(t, c)
 }
 
+// This test works only if local clickhouse is installed
+func TestClientDoWithRequestCompression(t *testing.T) {
+	c := &Client{
+		Timeout:                  5 * time.Second,
+		CompressRequest:          true,
+		CompressRequestThreshold: 1,
+	}
+	testClientDo(t, c)
+}
+
+// This test works only if local clickhouse is installed
+func TestClientDoContextWithID(t *testing.T) {
+	c := &Client{Timeout: 5 * time.Second}
+	queryID, err := c.DoContextWithID(context.Background(), "", "", "SELECT 1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if queryID == "" {
+		t.Fatalf("expecting auto-generated query_id to be returned")
+	}
+
+	queryID2, err := c.DoContextWithID(context.Background(), "my-query-id", "", "SELECT 1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if queryID2 != "my-query-id" {
+		t.Fatalf("got: %q; expected: %q", queryID2, "my-query-id")
+	}
+}
+
 func testClientDo(t *testing.T, c *Client) {
 	expectedRows := int(1e6)
 	query := fmt.Sprintf("SELECT number, number+1 FROM system.numbers LIMIT %d", expectedRows)
@@ -89,7 +122,10 @@ func TestPrepareRequest(t *testing.T) {
 			c := &Client{
 				URLParams: tc.params,
 			}
-			req := c.prepareRequest(c.addr(), "SELECT * FROM system.numbers LIMIT 10")
+			req, err := c.prepareRequest("localhost:8123", "SELECT * FROM system.numbers LIMIT 10", queryParams{format: TabSeparated})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
 			got := req.URL.String()
 			if got != tc.expected {
 				t.Fatalf("got: %q; expected: %q", got, tc.expected)
@@ -97,3 +133,59 @@ func TestPrepareRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestPrepareRequestCompression(t *testing.T) {
+	query := "SELECT * FROM system.numbers LIMIT 10"
+
+	c := &Client{
+		CompressRequest:          true,
+		CompressRequestThreshold: len(query) + 1,
+	}
+	req, err := c.prepareRequest("localhost:8123", query, queryParams{format: TabSeparated})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ce := req.Header.Get("Content-Encoding"); ce != "" {
+		t.Fatalf("unexpected Content-Encoding for body below threshold: %q", ce)
+	}
+
+	c.CompressRequestThreshold = len(query) - 1
+	req, err = c.prepareRequest("localhost:8123", query, queryParams{format: TabSeparated})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ce := req.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("unexpected Content-Encoding for body above threshold: %q", ce)
+	}
+	zr, err := gzip.NewReader(req.Body)
+	if err != nil {
+		t.Fatalf("cannot create gzip reader: %s", err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("cannot read gzipped body: %s", err)
+	}
+	if string(data) != query {
+		t.Fatalf("got: %q; expected: %q", data, query)
+	}
+}
+
+func TestPrepareRequestQueryIDAndQuotaKey(t *testing.T) {
+	c := &Client{}
+	req, err := c.prepareRequest("localhost:8123", "SELECT 1", queryParams{queryID: "my-query-id", quotaKey: "my-quota-key", format: TabSeparated})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "http://localhost:8123/?user=default&query_id=my-query-id&quota_key=my-quota-key"
+	if got := req.URL.String(); got != expected {
+		t.Fatalf("got: %q; expected: %q", got, expected)
+	}
+}
+
+func TestNewQueryIDIsUnique(t *testing.T) {
+	a := newQueryID()
+	b := newQueryID()
+	if a == b {
+		t.Fatalf("expecting distinct query_id values; got %q twice", a)
+	}
+}
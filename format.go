@@ -0,0 +1,169 @@
+package chclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/valyala/tsvreader"
+)
+
+// RowReader reads rows decoded from a clickhouse response in some Format.
+//
+// Concrete Format implementations return RowReader values with additional,
+// format-specific methods for accessing column data; callers type-assert
+// the RowReader passed to their callback to the type returned by the
+// Format's NewReader.
+type RowReader interface {
+	// Next advances to the next row. It returns false once there are no
+	// more rows or an error occurred; use Error to tell which happened.
+	Next() bool
+
+	// Error returns the first error encountered while reading rows.
+	Error() error
+}
+
+// Format describes how query results should be encoded by clickhouse and
+// how to decode them back into rows.
+type Format interface {
+	// URLParam returns the clickhouse FORMAT name, e.g. "TabSeparated".
+	URLParam() string
+
+	// ContentType returns the Content-Type clickhouse is expected to set
+	// on responses using this format.
+	ContentType() string
+
+	// NewReader wraps r into a RowReader for this format.
+	NewReader(r io.Reader) RowReader
+}
+
+// TabSeparated is the plain TabSeparated format used by Do and DoContext.
+var TabSeparated Format = tabSeparatedFormat{}
+
+// TabSeparatedWithNamesAndTypes is like TabSeparated, but clickhouse
+// prepends two extra rows containing column names and column types.
+var TabSeparatedWithNamesAndTypes Format = tabSeparatedWithNamesAndTypesFormat{}
+
+// JSONEachRow decodes each row as a standalone JSON object, one per line.
+// It is convenient for nested/array/IPv4/IPv6 columns, which are awkward
+// to parse from TabSeparated.
+var JSONEachRow Format = jsonEachRowFormat{}
+
+// RowBinary decodes clickhouse's native binary row format. Unlike the
+// other formats, clickhouse sends no column metadata, so the caller must
+// know the query's column order and types upfront; see RowBinaryReader.
+var RowBinary Format = rowBinaryFormat{}
+
+type tabSeparatedFormat struct{}
+
+func (tabSeparatedFormat) URLParam() string    { return "TabSeparated" }
+func (tabSeparatedFormat) ContentType() string { return "text/tab-separated-values" }
+
+func (tabSeparatedFormat) NewReader(r io.Reader) RowReader {
+	return tsvreader.New(r)
+}
+
+type tabSeparatedWithNamesAndTypesFormat struct{}
+
+func (tabSeparatedWithNamesAndTypesFormat) URLParam() string {
+	return "TabSeparatedWithNamesAndTypes"
+}
+
+func (tabSeparatedWithNamesAndTypesFormat) ContentType() string {
+	return "text/tab-separated-values"
+}
+
+func (tabSeparatedWithNamesAndTypesFormat) NewReader(r io.Reader) RowReader {
+	return newTSVHeaderReader(r)
+}
+
+// TSVHeaderReader is the RowReader returned by TabSeparatedWithNamesAndTypes.
+// It embeds *tsvreader.Reader for row access and additionally exposes the
+// column names and types clickhouse sent ahead of the data.
+type TSVHeaderReader struct {
+	*tsvreader.Reader
+
+	// Names holds the query's column names, in order.
+	Names []string
+
+	// Types holds the query's column types, in order.
+	Types []string
+}
+
+func newTSVHeaderReader(r io.Reader) *TSVHeaderReader {
+	br := bufio.NewReader(r)
+	names, _ := readTSVHeaderLine(br)
+	types, _ := readTSVHeaderLine(br)
+	return &TSVHeaderReader{
+		Reader: tsvreader.New(br),
+		Names:  names,
+		Types:  types,
+	}
+}
+
+func readTSVHeaderLine(br *bufio.Reader) ([]string, error) {
+	line, err := br.ReadString('\n')
+	line = strings.TrimSuffix(line, "\n")
+	if line == "" {
+		return nil, err
+	}
+	return strings.Split(line, "\t"), err
+}
+
+type jsonEachRowFormat struct{}
+
+func (jsonEachRowFormat) URLParam() string { return "JSONEachRow" }
+
+// ContentType returns "application/x-ndjson", which is what clickhouse
+// actually sets for JSONEachRow responses (as
+// "application/x-ndjson; charset=UTF-8"); doQuery matches it with
+// strings.HasPrefix, so the charset suffix doesn't matter here.
+func (jsonEachRowFormat) ContentType() string { return "application/x-ndjson" }
+
+func (jsonEachRowFormat) NewReader(r io.Reader) RowReader {
+	return &JSONEachRowReader{dec: json.NewDecoder(r)}
+}
+
+// JSONEachRowReader is the RowReader returned by JSONEachRow. Each row is
+// decoded into a map keyed by column name.
+type JSONEachRowReader struct {
+	dec *json.Decoder
+	row map[string]interface{}
+	err error
+}
+
+// Next decodes the next JSON row. See RowReader.Next.
+func (r *JSONEachRowReader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	var row map[string]interface{}
+	if err := r.dec.Decode(&row); err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		return false
+	}
+	r.row = row
+	return true
+}
+
+// Row returns the row decoded by the most recent call to Next.
+func (r *JSONEachRowReader) Row() map[string]interface{} {
+	return r.row
+}
+
+// Error returns the first error encountered while decoding rows.
+func (r *JSONEachRowReader) Error() error {
+	return r.err
+}
+
+type rowBinaryFormat struct{}
+
+func (rowBinaryFormat) URLParam() string    { return "RowBinary" }
+func (rowBinaryFormat) ContentType() string { return "application/octet-stream" }
+
+func (rowBinaryFormat) NewReader(r io.Reader) RowReader {
+	return newRowBinaryReader(r)
+}
@@ -0,0 +1,100 @@
+package chclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTSVHeaderReader(t *testing.T) {
+	data := "num1\tnum2\nUInt32\tUInt32\n1\t2\n3\t4\n"
+	r := newTSVHeaderReader(strings.NewReader(data))
+
+	if got := strings.Join(r.Names, ","); got != "num1,num2" {
+		t.Fatalf("got Names: %q; expected: %q", got, "num1,num2")
+	}
+	if got := strings.Join(r.Types, ","); got != "UInt32,UInt32" {
+		t.Fatalf("got Types: %q; expected: %q", got, "UInt32,UInt32")
+	}
+
+	rows := 0
+	for r.Next() {
+		a := r.Int()
+		b := r.Int()
+		if b != a+1 {
+			t.Fatalf("got col2=%d for col1=%d; expecting %d", b, a, a+1)
+		}
+		rows++
+	}
+	if err := r.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rows != 2 {
+		t.Fatalf("got %d rows; expecting 2", rows)
+	}
+}
+
+func TestJSONEachRowReader(t *testing.T) {
+	data := `{"num":1,"str":"foo"}` + "\n" + `{"num":2,"str":"bar"}` + "\n"
+	r := &JSONEachRowReader{dec: json.NewDecoder(strings.NewReader(data))}
+
+	var rows []map[string]interface{}
+	for r.Next() {
+		rows = append(rows, r.Row())
+	}
+	if err := r.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows; expecting 2", len(rows))
+	}
+	if rows[0]["str"] != "foo" || rows[1]["str"] != "bar" {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+}
+
+func TestRowBinaryReader(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte(3)       // varint-encoded string length
+	buf.WriteString("foo") // String
+	buf.WriteByte(42)      // UInt8
+
+	r := newRowBinaryReader(&buf)
+	if !r.Next() {
+		t.Fatalf("expecting a row to be available")
+	}
+	if s := r.ReadString(); s != "foo" {
+		t.Fatalf("got: %q; expected: %q", s, "foo")
+	}
+	if v := r.ReadUInt8(); v != 42 {
+		t.Fatalf("got: %d; expected: 42", v)
+	}
+	if err := r.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if r.Next() {
+		t.Fatalf("expecting no more rows")
+	}
+}
+
+// This test works only if local clickhouse is installed
+func TestClientDoFormatJSONEachRow(t *testing.T) {
+	c := &Client{}
+	err := c.DoFormat(context.Background(), "SELECT number FROM system.numbers LIMIT 3", JSONEachRow, func(rr RowReader) error {
+		r := rr.(*JSONEachRowReader)
+		i := 0
+		for r.Next() {
+			i++
+		}
+		if i != 3 {
+			return fmt.Errorf("got %d rows; expecting 3", i)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,371 @@
+package chclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFlushRows is the default value for Client.FlushRows.
+var DefaultFlushRows = 1000
+
+// DefaultFlushBytes is the default value for Client.FlushBytes.
+var DefaultFlushBytes = 1 << 20
+
+// DefaultFlushInterval is the default value for Client.FlushInterval.
+var DefaultFlushInterval = time.Second
+
+// Insert opens a streaming INSERT into table for the given columns,
+// returning an InsertWriter that rows can be written into with WriteRow
+// or WriteRaw.
+//
+// Rows are buffered internally and flushed to clickhouse once
+// Client.FlushRows, Client.FlushBytes or Client.FlushInterval is reached,
+// so callers feeding high-throughput ingest pipelines don't have to
+// build multi-megabyte request bodies in memory before streaming them.
+//
+// format selects the wire format used for the INSERT and, together with
+// it, which values WriteRow accepts: TabSeparated and JSONEachRow encode
+// vals directly; any other format requires pre-encoded rows to be written
+// with WriteRaw instead. If format is nil, Client.DefaultFormat is used,
+// which is TabSeparated unless set.
+//
+// Unlike Do/DoContext, Insert doesn't retry across Client.Addrs: once
+// rows have started streaming to a host, there is no buffered request
+// body left to replay against another one if it fails.
+//
+// The returned *InsertWriter must be closed with Close, which also
+// reports whether clickhouse accepted the INSERT.
+func (c *Client) Insert(ctx context.Context, table string, columns []string, format Format) (*InsertWriter, error) {
+	if format == nil {
+		format = c.defaultFormat()
+	}
+
+	hosts := c.pool().order(c.hostPolicy())
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("chclient: no clickhouse hosts configured")
+	}
+	h := hosts[0]
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) FORMAT %s\n", table, strings.Join(columns, ", "), format.URLParam())
+
+	compress := c.CompressRequest
+	pr, pw := io.Pipe()
+
+	req, err := c.prepareInsertRequest(h.addr, compress)
+	if err != nil {
+		pr.Close()
+		return nil, fmt.Errorf("cannot prepare INSERT request into %q at %q: %s", table, h.addr, err)
+	}
+	req = req.WithContext(ctx)
+	req.Body = ioutil.NopCloser(pr)
+
+	w := &InsertWriter{
+		columns:       columns,
+		format:        format,
+		pw:            pw,
+		errCh:         make(chan error, 1),
+		flushRows:     c.flushRows(),
+		flushBytes:    c.flushBytes(),
+		flushInterval: c.flushInterval(),
+		stopFlusher:   make(chan struct{}),
+	}
+	w.wire = pw
+	if compress {
+		w.gzw = gzip.NewWriter(pw)
+		w.wire = w.gzw
+	}
+
+	// http.DefaultClient.Do must be reading from pr before anything is
+	// written to pw, since io.Pipe is unbuffered: a Write blocks until a
+	// matching Read consumes it.
+	h.addInflight(1)
+	go func() {
+		defer h.addInflight(-1)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			pr.CloseWithError(err)
+			// A ctx cancellation/deadline isn't h's fault: don't count it
+			// against its health.
+			if ctx.Err() == nil {
+				h.recordFailure(c.maxFailures(), c.unhealthyCooldown())
+				if c.OnHostFailure != nil {
+					c.OnHostFailure(h.addr, err)
+				}
+			}
+			w.errCh <- err
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			err := fmt.Errorf("unexpected status code for INSERT into %q sent to %q: %d. Response body: %q",
+				table, h.addr, resp.StatusCode, respBody)
+			pr.CloseWithError(err)
+			h.recordFailure(c.maxFailures(), c.unhealthyCooldown())
+			if c.OnHostFailure != nil {
+				c.OnHostFailure(h.addr, err)
+			}
+			w.errCh <- err
+			return
+		}
+		if h.recordSuccess() && c.OnHostRecover != nil {
+			c.OnHostRecover(h.addr)
+		}
+		w.errCh <- nil
+	}()
+
+	if _, err := w.wire.Write([]byte(query)); err != nil {
+		<-w.errCh
+		return nil, fmt.Errorf("cannot write INSERT query into %q at %q: %s", table, h.addr, err)
+	}
+
+	go w.runFlusher()
+
+	return w, nil
+}
+
+func (c *Client) prepareInsertRequest(addr string, compress bool) (*http.Request, error) {
+	scheme := "http"
+	if c.UseHTTPS {
+		scheme = "https"
+	}
+
+	args := make([]string, 0, len(c.URLParams)+3)
+	args = append(args, c.URLParams...)
+	args = append(args, fmt.Sprintf("user=%s", url.QueryEscape(c.user())))
+	if c.Password != "" {
+		args = append(args, fmt.Sprintf("password=%s", url.QueryEscape(c.Password)))
+	}
+	if c.Database != "" {
+		args = append(args, fmt.Sprintf("database=%s", url.QueryEscape(c.Database)))
+	}
+	if compress {
+		args = append(args, "enable_http_compression=1")
+	}
+	xurl := fmt.Sprintf("%s://%s/?%s", scheme, addr, strings.Join(args, "&"))
+
+	req, err := http.NewRequest("POST", xurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return req, nil
+}
+
+// InsertWriter streams rows into a clickhouse INSERT query opened by
+// Client.Insert.
+//
+// An InsertWriter isn't safe for concurrent use. It must be closed with
+// Close once the caller is done writing rows.
+type InsertWriter struct {
+	columns []string
+	format  Format
+
+	wire io.Writer
+	gzw  *gzip.Writer
+	pw   *io.PipeWriter
+
+	errCh chan error
+
+	flushRows     int
+	flushBytes    int
+	flushInterval time.Duration
+	stopFlusher   chan struct{}
+	stopOnce      sync.Once
+
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	rows   int
+	closed bool
+}
+
+// WriteRow encodes vals as a single row in the InsertWriter's format and
+// buffers it for sending to clickhouse. vals must match the columns
+// passed to Client.Insert in both count and order.
+//
+// WriteRow supports the TabSeparated family of formats (the default) and
+// JSONEachRow. Any other format requires pre-encoded rows written with
+// WriteRaw instead.
+func (w *InsertWriter) WriteRow(vals ...interface{}) error {
+	row, err := w.encodeRow(vals)
+	if err != nil {
+		return err
+	}
+	return w.WriteRaw(row)
+}
+
+// WriteRaw appends p, which must already be a valid, terminated row (or
+// rows) in the InsertWriter's wire format, to the buffered output.
+func (w *InsertWriter) WriteRaw(p []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return fmt.Errorf("chclient: InsertWriter is already closed")
+	}
+
+	w.buf.Write(p)
+	w.rows++
+	if w.rows >= w.flushRows || w.buf.Len() >= w.flushBytes {
+		return w.flushLocked()
+	}
+	return nil
+}
+
+// Flush immediately sends any buffered rows to clickhouse, instead of
+// waiting for FlushRows, FlushBytes or FlushInterval to be reached.
+func (w *InsertWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return fmt.Errorf("chclient: InsertWriter is already closed")
+	}
+	return w.flushLocked()
+}
+
+// Close flushes any buffered rows, finishes the INSERT and blocks until
+// clickhouse confirms (or rejects) it.
+func (w *InsertWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return fmt.Errorf("chclient: InsertWriter is already closed")
+	}
+	flushErr := w.flushLocked()
+	w.closed = true
+	w.mu.Unlock()
+
+	w.stopOnce.Do(func() { close(w.stopFlusher) })
+
+	var closeErr error
+	if w.gzw != nil {
+		closeErr = w.gzw.Close()
+	}
+	if pwErr := w.pw.Close(); closeErr == nil {
+		closeErr = pwErr
+	}
+
+	err := <-w.errCh
+	if flushErr != nil {
+		return flushErr
+	}
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (w *InsertWriter) runFlusher() {
+	t := time.NewTicker(w.flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.mu.Lock()
+			if !w.closed {
+				w.flushLocked()
+			}
+			w.mu.Unlock()
+		case <-w.stopFlusher:
+			return
+		}
+	}
+}
+
+// flushLocked writes buffered rows to the wire. w.mu must be held.
+func (w *InsertWriter) flushLocked() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.wire.Write(w.buf.Bytes())
+	if err == nil && w.gzw != nil {
+		// Push the compressed rows out of the gzip writer's buffer.
+		err = w.gzw.Flush()
+	}
+	w.buf.Reset()
+	w.rows = 0
+	if err != nil {
+		w.closed = true
+		// The wire is broken, so runFlusher won't get another chance to
+		// drain stopFlusher via Close; stop it now to avoid leaking it.
+		w.stopOnce.Do(func() { close(w.stopFlusher) })
+	}
+	return err
+}
+
+func (w *InsertWriter) encodeRow(vals []interface{}) ([]byte, error) {
+	if w.format == JSONEachRow {
+		return encodeJSONRow(w.columns, vals)
+	}
+	return encodeTSVRow(vals), nil
+}
+
+func encodeTSVRow(vals []interface{}) []byte {
+	parts := make([]string, len(vals))
+	for i, v := range vals {
+		if s, ok := v.(string); ok {
+			parts[i] = tsvEscape(s)
+		} else {
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	return []byte(strings.Join(parts, "\t") + "\n")
+}
+
+func tsvEscape(s string) string {
+	if !strings.ContainsAny(s, "\\\t\n\r") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+	return r.Replace(s)
+}
+
+func encodeJSONRow(columns []string, vals []interface{}) ([]byte, error) {
+	if len(vals) != len(columns) {
+		return nil, fmt.Errorf("got %d values; expecting %d to match columns %v", len(vals), len(columns), columns)
+	}
+	row := make(map[string]interface{}, len(columns))
+	for i, name := range columns {
+		row[name] = vals[i]
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal row %v to JSON: %s", row, err)
+	}
+	return append(data, '\n'), nil
+}
+
+func (c *Client) flushRows() int {
+	if c.FlushRows <= 0 {
+		return DefaultFlushRows
+	}
+	return c.FlushRows
+}
+
+func (c *Client) flushBytes() int {
+	if c.FlushBytes <= 0 {
+		return DefaultFlushBytes
+	}
+	return c.FlushBytes
+}
+
+func (c *Client) flushInterval() time.Duration {
+	if c.FlushInterval <= 0 {
+		return DefaultFlushInterval
+	}
+	return c.FlushInterval
+}
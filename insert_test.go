@@ -0,0 +1,168 @@
+package chclient
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestInsertWriterWritesRows(t *testing.T) {
+	bodyCh := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		bodyCh <- string(data)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("cannot parse server URL: %s", err)
+	}
+
+	c := &Client{Addrs: []string{u.Host}}
+	iw, err := c.Insert(context.Background(), "t", []string{"a", "b"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := iw.WriteRow(1, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := iw.WriteRow(2, "bar\tbaz"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := iw.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case body := <-bodyCh:
+		expected := "INSERT INTO t (a, b) FORMAT TabSeparated\n1\tfoo\n2\tbar\\tbaz\n"
+		if body != expected {
+			t.Fatalf("got: %q; expected: %q", body, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the INSERT request")
+	}
+}
+
+func TestInsertWriterFlushRows(t *testing.T) {
+	lineCh := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		br := bufio.NewReader(r.Body)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				lineCh <- line
+			}
+			if err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("cannot parse server URL: %s", err)
+	}
+
+	c := &Client{
+		Addrs:         []string{u.Host},
+		FlushRows:     2,
+		FlushInterval: time.Hour,
+	}
+	iw, err := c.Insert(context.Background(), "t", []string{"a"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := iw.WriteRow(1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := iw.WriteRow(2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The header line plus both rows should reach the server as soon as
+	// FlushRows is hit, well before Close is called.
+	for i, expected := range []string{"INSERT INTO t (a) FORMAT TabSeparated\n", "1\n", "2\n"} {
+		select {
+		case line := <-lineCh:
+			if line != expected {
+				t.Fatalf("line %d: got: %q; expected: %q", i, line, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for line %d to reach the server", i)
+		}
+	}
+
+	if err := iw.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestInsertWriterJSONEachRow(t *testing.T) {
+	bodyCh := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		bodyCh <- string(data)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("cannot parse server URL: %s", err)
+	}
+
+	c := &Client{Addrs: []string{u.Host}}
+	iw, err := c.Insert(context.Background(), "t", []string{"num", "str"}, JSONEachRow)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := iw.WriteRow(1, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := iw.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case body := <-bodyCh:
+		expected := "INSERT INTO t (num, str) FORMAT JSONEachRow\n" + `{"num":1,"str":"foo"}` + "\n"
+		if body != expected {
+			t.Fatalf("got: %q; expected: %q", body, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the INSERT request")
+	}
+}
+
+func TestInsertWriterServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("boom"))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("cannot parse server URL: %s", err)
+	}
+
+	c := &Client{Addrs: []string{u.Host}}
+	iw, err := c.Insert(context.Background(), "t", []string{"a"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := iw.WriteRow(1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := iw.Close(); err == nil {
+		t.Fatalf("expecting an error from Close after a server error")
+	}
+}
@@ -0,0 +1,189 @@
+package chclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paramPlaceholderRe matches clickhouse's server-side bound parameter
+// syntax, e.g. {id:UInt32} or {names:Array(String)}.
+var paramPlaceholderRe = regexp.MustCompile(`\{(\w+):(\w+(?:\([^{}]*\))?)\}`)
+
+// DoParams behaves like DoContext, but additionally sends params as
+// clickhouse's native `param_<name>` query parameters, so that query's
+// `{name:Type}` placeholders are bound server-side instead of requiring
+// the caller to build the query string by hand. It supports String,
+// Int8..Int64, UInt8..UInt64, Float32/Float64, Date, DateTime, UUID,
+// IPv4, IPv6 and Array(T) of any of the above.
+//
+// query is sent to clickhouse unchanged, placeholders and all: the
+// binding happens entirely server-side, so params never need to be
+// escaped into the query text.
+//
+// f may be nil if query result isn't needed.
+func (c *Client) DoParams(ctx context.Context, query string, params map[string]interface{}, f ReadRowsFunc) error {
+	paramArgs, err := collectParamArgs(query, params)
+	if err != nil {
+		return err
+	}
+	queryID, _ := queryIDFromContext(ctx)
+	qp := queryParams{queryID: queryID, format: TabSeparated, paramArgs: paramArgs}
+	_, err = c.doQuery(ctx, query, qp, readRowsFuncToRowReaderFunc(f))
+	return err
+}
+
+// collectParamArgs finds every `{name:Type}` placeholder in query and
+// returns the `param_<name>=...` URL arguments clickhouse needs to bind
+// them, encoding each params[name] per Type.
+func collectParamArgs(query string, params map[string]interface{}) ([]string, error) {
+	types := make(map[string]string)
+	var rerr error
+	paramPlaceholderRe.ReplaceAllStringFunc(query, func(tok string) string {
+		if rerr != nil {
+			return tok
+		}
+		m := paramPlaceholderRe.FindStringSubmatch(tok)
+		name, typ := m[1], m[2]
+		if _, ok := params[name]; !ok {
+			rerr = fmt.Errorf("chclient: missing value for query parameter %q", name)
+			return tok
+		}
+		types[name] = typ
+		return tok
+	})
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	paramArgs := make([]string, 0, len(types))
+	for name, typ := range types {
+		encoded, err := encodeParamURL(typ, params[name])
+		if err != nil {
+			return nil, fmt.Errorf("chclient: cannot encode query parameter %q as %s: %s", name, typ, err)
+		}
+		paramArgs = append(paramArgs, fmt.Sprintf("param_%s=%s", name, url.QueryEscape(encoded)))
+	}
+	sort.Strings(paramArgs)
+	return paramArgs, nil
+}
+
+// encodeParamURL renders val as a Type value for clickhouse's `param_name`
+// URL argument: strings are backslash-escaped, and arrays are encoded as
+// a `[elem,elem,...]` literal of their backslash-escaped elements.
+func encodeParamURL(typ string, val interface{}) (string, error) {
+	if inner, ok := arrayElemType(typ); ok {
+		return encodeParamArray(inner, val)
+	}
+	switch typ {
+	case "String", "UUID", "IPv4", "IPv6":
+		s, err := paramString(val)
+		if err != nil {
+			return "", err
+		}
+		return backslashEscape(s), nil
+	case "Date":
+		t, err := paramTime(val)
+		if err != nil {
+			return "", err
+		}
+		return t.Format("2006-01-02"), nil
+	case "DateTime":
+		t, err := paramTime(val)
+		if err != nil {
+			return "", err
+		}
+		return t.Format("2006-01-02 15:04:05"), nil
+	default:
+		return paramNumber(typ, val)
+	}
+}
+
+func arrayElemType(typ string) (string, bool) {
+	if !strings.HasPrefix(typ, "Array(") || !strings.HasSuffix(typ, ")") {
+		return "", false
+	}
+	return typ[len("Array(") : len(typ)-1], true
+}
+
+func encodeParamArray(elemType string, val interface{}) (string, error) {
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Slice {
+		return "", fmt.Errorf("expecting a slice for Array(%s), got %T", elemType, val)
+	}
+	parts := make([]string, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		p, err := encodeParamURL(elemType, rv.Index(i).Interface())
+		if err != nil {
+			return "", err
+		}
+		parts[i] = p
+	}
+	return "[" + strings.Join(parts, ",") + "]", nil
+}
+
+func paramString(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case fmt.Stringer:
+		return v.String(), nil
+	default:
+		return "", fmt.Errorf("expecting a string, got %T", val)
+	}
+}
+
+func paramTime(val interface{}) (time.Time, error) {
+	t, ok := val.(time.Time)
+	if !ok {
+		return time.Time{}, fmt.Errorf("expecting a time.Time, got %T", val)
+	}
+	return t, nil
+}
+
+func paramNumber(typ string, val interface{}) (string, error) {
+	switch v := val.(type) {
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("expecting a number for %s, got %T", typ, val)
+	}
+}
+
+// backslashEscape escapes s per clickhouse's Escaped format, which is how
+// it parses `param_<name>` String values: a raw tab, newline or carriage
+// return is a field/row delimiter, so those (and the backslash introducing
+// the escape itself) must be escaped. Unlike SQL literals, Escaped format
+// doesn't treat a quote as special.
+func backslashEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+	return r.Replace(s)
+}
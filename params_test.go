@@ -0,0 +1,111 @@
+package chclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCollectParamArgsHappyPath(t *testing.T) {
+	query := "SELECT * FROM t WHERE id = {id:UInt32} AND name = {name:String}"
+	params := map[string]interface{}{
+		"id":   uint32(42),
+		"name": "foo'bar",
+	}
+	paramArgs, err := collectParamArgs(query, params)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedArgs := "param_id=42&param_name=foo%27bar"
+	if got := joinArgs(paramArgs); got != expectedArgs {
+		t.Fatalf("got: %q; expected: %q", got, expectedArgs)
+	}
+}
+
+func TestCollectParamArgsBackslash(t *testing.T) {
+	paramArgs, err := collectParamArgs("SELECT {s:String}", map[string]interface{}{
+		"s": `foo\bar`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "param_s=foo%5C%5Cbar"; joinArgs(paramArgs) != expected {
+		t.Fatalf("got: %q; expected: %q", joinArgs(paramArgs), expected)
+	}
+}
+
+func TestCollectParamArgsEscapesDelimiters(t *testing.T) {
+	paramArgs, err := collectParamArgs("SELECT {s:String}", map[string]interface{}{
+		"s": "foo\tbar\nbaz\r",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "param_s=foo%5Ctbar%5Cnbaz%5Cr"; joinArgs(paramArgs) != expected {
+		t.Fatalf("got: %q; expected: %q", joinArgs(paramArgs), expected)
+	}
+}
+
+func TestCollectParamArgsMissingValue(t *testing.T) {
+	_, err := collectParamArgs("SELECT {id:UInt32}", nil)
+	if err == nil {
+		t.Fatalf("expecting an error for a missing parameter value")
+	}
+}
+
+func TestCollectParamArgsUnsupportedType(t *testing.T) {
+	_, err := collectParamArgs("SELECT {id:UInt32}", map[string]interface{}{
+		"id": "not a number",
+	})
+	if err == nil {
+		t.Fatalf("expecting an error for an unsupported value type")
+	}
+}
+
+func TestCollectParamArgsArray(t *testing.T) {
+	paramArgs, err := collectParamArgs("SELECT {ids:Array(UInt32)}", map[string]interface{}{
+		"ids": []uint32{1, 2, 3},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "param_ids=%5B1%2C2%2C3%5D"; joinArgs(paramArgs) != expected {
+		t.Fatalf("got: %q; expected: %q", joinArgs(paramArgs), expected)
+	}
+}
+
+func TestCollectParamArgsDateAndDateTime(t *testing.T) {
+	d := time.Date(2023, time.May, 1, 12, 30, 0, 0, time.UTC)
+	paramArgs, err := collectParamArgs("SELECT {d:Date}, {dt:DateTime}", map[string]interface{}{
+		"d":  d,
+		"dt": d,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "param_d=2023-05-01&param_dt=2023-05-01+12%3A30%3A00"
+	if got := joinArgs(paramArgs); got != expected {
+		t.Fatalf("got: %q; expected: %q", got, expected)
+	}
+}
+
+func TestCollectParamArgsInt(t *testing.T) {
+	query := "SELECT * FROM t WHERE id = {id:UInt32}"
+	paramArgs, err := collectParamArgs(query, map[string]interface{}{"id": 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(paramArgs) != 1 || paramArgs[0] != "param_id=1" {
+		t.Fatalf("unexpected paramArgs: %v", paramArgs)
+	}
+}
+
+func joinArgs(args []string) string {
+	s := ""
+	for i, a := range args {
+		if i > 0 {
+			s += "&"
+		}
+		s += a
+	}
+	return s
+}
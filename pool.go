@@ -0,0 +1,130 @@
+package chclient
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HostPolicy determines the order in which Client tries healthy hosts
+// from Client.Addrs.
+type HostPolicy int
+
+const (
+	// RoundRobin cycles through healthy hosts in turn.
+	RoundRobin HostPolicy = iota
+
+	// LeastLoaded picks the healthy host with the fewest in-flight requests.
+	LeastLoaded
+
+	// Random picks a healthy host at random.
+	Random
+)
+
+// DefaultMaxFailures is the default value for Client.MaxFailures.
+var DefaultMaxFailures = 3
+
+// DefaultUnhealthyCooldown is the default value for Client.UnhealthyCooldown.
+var DefaultUnhealthyCooldown = 10 * time.Second
+
+// hostPool tracks health state for a fixed set of clickhouse hosts.
+type hostPool struct {
+	hosts []*hostState
+
+	rrIdx uint64 // atomic
+}
+
+func newHostPool(addrs []string) *hostPool {
+	hosts := make([]*hostState, len(addrs))
+	for i, addr := range addrs {
+		hosts[i] = &hostState{addr: addr}
+	}
+	return &hostPool{hosts: hosts}
+}
+
+// order returns p's hosts arranged in try-order for the given policy, with
+// healthy hosts first. If every host is currently unhealthy, all of them
+// are returned anyway so the pool can self-heal once one starts responding.
+func (p *hostPool) order(policy HostPolicy) []*hostState {
+	healthy := make([]*hostState, 0, len(p.hosts))
+	unhealthy := make([]*hostState, 0)
+	for _, h := range p.hosts {
+		if h.isHealthy() {
+			healthy = append(healthy, h)
+		} else {
+			unhealthy = append(unhealthy, h)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy, unhealthy = unhealthy, healthy
+	}
+
+	switch policy {
+	case LeastLoaded:
+		sort.Slice(healthy, func(i, j int) bool {
+			return healthy[i].loadedInflight() < healthy[j].loadedInflight()
+		})
+	case Random:
+		rand.Shuffle(len(healthy), func(i, j int) {
+			healthy[i], healthy[j] = healthy[j], healthy[i]
+		})
+	default: // RoundRobin
+		if n := len(healthy); n > 1 {
+			idx := int(atomic.AddUint64(&p.rrIdx, 1)) % n
+			healthy = append(healthy[idx:], healthy[:idx]...)
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// hostState tracks in-flight load and consecutive-failure health for a
+// single clickhouse host.
+type hostState struct {
+	addr string
+
+	inflight int64 // atomic
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+	unhealthyUntil      time.Time
+}
+
+func (h *hostState) addInflight(delta int64) {
+	atomic.AddInt64(&h.inflight, delta)
+}
+
+func (h *hostState) loadedInflight() int64 {
+	return atomic.LoadInt64(&h.inflight)
+}
+
+func (h *hostState) isHealthy() bool {
+	h.mu.Lock()
+	healthy := !time.Now().Before(h.unhealthyUntil)
+	h.mu.Unlock()
+	return healthy
+}
+
+// recordFailure registers a failed request and marks h unhealthy for
+// cooldown once it has failed maxFailures times in a row.
+func (h *hostState) recordFailure(maxFailures int, cooldown time.Duration) {
+	h.mu.Lock()
+	h.consecutiveFailures++
+	if h.consecutiveFailures >= maxFailures {
+		h.unhealthyUntil = time.Now().Add(cooldown)
+	}
+	h.mu.Unlock()
+}
+
+// recordSuccess registers a successful request and reports whether h was
+// previously marked unhealthy.
+func (h *hostState) recordSuccess() bool {
+	h.mu.Lock()
+	recovered := !h.unhealthyUntil.IsZero()
+	h.consecutiveFailures = 0
+	h.unhealthyUntil = time.Time{}
+	h.mu.Unlock()
+	return recovered
+}
@@ -0,0 +1,67 @@
+package chclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostPoolRoundRobin(t *testing.T) {
+	p := newHostPool([]string{"h1", "h2", "h3"})
+
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		hosts := p.order(RoundRobin)
+		seen[hosts[0].addr]++
+	}
+	for _, addr := range []string{"h1", "h2", "h3"} {
+		if seen[addr] != 2 {
+			t.Fatalf("host %q was picked first %d times; expecting 2", addr, seen[addr])
+		}
+	}
+}
+
+func TestHostPoolLeastLoaded(t *testing.T) {
+	p := newHostPool([]string{"h1", "h2"})
+	p.hosts[0].addInflight(5)
+
+	hosts := p.order(LeastLoaded)
+	if hosts[0].addr != "h2" {
+		t.Fatalf("expecting least loaded host h2 first; got %q", hosts[0].addr)
+	}
+}
+
+func TestHostPoolUnhealthyFallback(t *testing.T) {
+	p := newHostPool([]string{"h1", "h2"})
+	p.hosts[0].recordFailure(1, time.Minute)
+
+	hosts := p.order(RoundRobin)
+	if hosts[0].addr != "h2" {
+		t.Fatalf("expecting healthy host h2 first; got %q", hosts[0].addr)
+	}
+	if hosts[1].addr != "h1" {
+		t.Fatalf("expecting unhealthy host h1 last; got %q", hosts[1].addr)
+	}
+
+	// Once every host is unhealthy, all of them must still be returned.
+	p.hosts[1].recordFailure(1, time.Minute)
+	hosts = p.order(RoundRobin)
+	if len(hosts) != 2 {
+		t.Fatalf("expecting 2 hosts even when all are unhealthy; got %d", len(hosts))
+	}
+}
+
+func TestHostStateRecoversOnSuccess(t *testing.T) {
+	h := &hostState{addr: "h1"}
+	h.recordFailure(1, time.Minute)
+	if h.isHealthy() {
+		t.Fatalf("host must be unhealthy right after reaching MaxFailures")
+	}
+
+	recovered := h.recordSuccess()
+	if !recovered {
+		t.Fatalf("expecting recordSuccess to report recovery from an unhealthy state")
+	}
+	if !h.isHealthy() {
+		t.Fatalf("host must be healthy after a successful request")
+	}
+}
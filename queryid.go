@@ -0,0 +1,56 @@
+package chclient
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const queryIDContextKey contextKey = 0
+
+// WithQueryID returns a copy of ctx carrying queryID, which DoContext will
+// send to clickhouse as query_id instead of auto-generating one.
+func WithQueryID(ctx context.Context, queryID string) context.Context {
+	return context.WithValue(ctx, queryIDContextKey, queryID)
+}
+
+func queryIDFromContext(ctx context.Context) (string, bool) {
+	queryID, ok := ctx.Value(queryIDContextKey).(string)
+	return queryID, ok
+}
+
+// DefaultKillQueryTimeout is the default value for Client.KillQueryTimeout.
+var DefaultKillQueryTimeout = 5 * time.Second
+
+// newQueryID generates a random RFC 4122 v4 UUID for use as clickhouse's
+// query_id.
+func newQueryID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("BUG: cannot read random bytes for query_id: %s", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// killQuery asks clickhouse at addr to kill the query identified by
+// queryID. It is called after ctx is canceled or its deadline is exceeded,
+// so the original request is already lost — errors here aren't actionable
+// and are intentionally ignored.
+func (c *Client) killQuery(addr, queryID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.killQueryTimeout())
+	defer cancel()
+	query := fmt.Sprintf("KILL QUERY WHERE query_id = %s", quoteSQLString(queryID))
+	_, _ = c.doRequest(ctx, addr, query, queryParams{format: TabSeparated})
+}
+
+func quoteSQLString(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "'", "''", -1)
+	return "'" + s + "'"
+}
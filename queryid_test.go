@@ -0,0 +1,51 @@
+package chclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDoContextKillsQueryOnCancellation(t *testing.T) {
+	killed := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("query_id") == "the-query" {
+			// Simulate a long-running query: the client gives up on it
+			// well before this returns.
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+		if q.Get("query_id") == "" {
+			data, _ := ioutil.ReadAll(r.Body)
+			killed <- string(data)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("cannot parse server URL: %s", err)
+	}
+
+	c := &Client{Addrs: []string{u.Host}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.DoContextWithID(ctx, "the-query", "", "SELECT 1", nil); err == nil {
+		t.Fatalf("expecting an error after ctx is canceled")
+	}
+
+	select {
+	case q := <-killed:
+		if q != "KILL QUERY WHERE query_id = 'the-query'" {
+			t.Fatalf("unexpected kill query: %q", q)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the KILL QUERY request")
+	}
+}
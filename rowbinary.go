@@ -0,0 +1,139 @@
+package chclient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// RowBinaryReader is the RowReader returned by RowBinary. Clickhouse sends
+// no column metadata in this format, so the caller must read each row's
+// columns in the query's own order using the typed Read* methods below.
+type RowBinaryReader struct {
+	br  *bufio.Reader
+	err error
+}
+
+func newRowBinaryReader(r io.Reader) *RowBinaryReader {
+	return &RowBinaryReader{br: bufio.NewReader(r)}
+}
+
+// Next reports whether another row is available to read. Callers must
+// read exactly the query's columns, in order, with the Read* methods
+// below before calling Next again.
+func (r *RowBinaryReader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	if _, err := r.br.Peek(1); err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		return false
+	}
+	return true
+}
+
+// Error returns the first error encountered while reading rows.
+func (r *RowBinaryReader) Error() error {
+	return r.err
+}
+
+// ReadUInt8 reads a single UInt8 column value.
+func (r *RowBinaryReader) ReadUInt8() uint8 {
+	var v uint8
+	r.read(&v)
+	return v
+}
+
+// ReadUInt16 reads a single UInt16 column value.
+func (r *RowBinaryReader) ReadUInt16() uint16 {
+	var v uint16
+	r.read(&v)
+	return v
+}
+
+// ReadUInt32 reads a single UInt32 column value.
+func (r *RowBinaryReader) ReadUInt32() uint32 {
+	var v uint32
+	r.read(&v)
+	return v
+}
+
+// ReadUInt64 reads a single UInt64 column value.
+func (r *RowBinaryReader) ReadUInt64() uint64 {
+	var v uint64
+	r.read(&v)
+	return v
+}
+
+// ReadInt8 reads a single Int8 column value.
+func (r *RowBinaryReader) ReadInt8() int8 {
+	var v int8
+	r.read(&v)
+	return v
+}
+
+// ReadInt16 reads a single Int16 column value.
+func (r *RowBinaryReader) ReadInt16() int16 {
+	var v int16
+	r.read(&v)
+	return v
+}
+
+// ReadInt32 reads a single Int32 column value.
+func (r *RowBinaryReader) ReadInt32() int32 {
+	var v int32
+	r.read(&v)
+	return v
+}
+
+// ReadInt64 reads a single Int64 column value.
+func (r *RowBinaryReader) ReadInt64() int64 {
+	var v int64
+	r.read(&v)
+	return v
+}
+
+// ReadFloat32 reads a single Float32 column value.
+func (r *RowBinaryReader) ReadFloat32() float32 {
+	var v float32
+	r.read(&v)
+	return v
+}
+
+// ReadFloat64 reads a single Float64 column value.
+func (r *RowBinaryReader) ReadFloat64() float64 {
+	var v float64
+	r.read(&v)
+	return v
+}
+
+func (r *RowBinaryReader) read(v interface{}) {
+	if r.err != nil {
+		return
+	}
+	if err := binary.Read(r.br, binary.LittleEndian, v); err != nil {
+		r.err = err
+	}
+}
+
+// ReadString reads a single String column value. Clickhouse encodes it as
+// a length-prefixed byte sequence, with the length itself encoded as an
+// LEB128 varint.
+func (r *RowBinaryReader) ReadString() string {
+	if r.err != nil {
+		return ""
+	}
+	n, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		r.err = err
+		return ""
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.br, buf); err != nil {
+		r.err = err
+		return ""
+	}
+	return string(buf)
+}
@@ -0,0 +1,63 @@
+package chclient
+
+import (
+	"context"
+	"time"
+)
+
+// Session carries a clickhouse session_id across multiple queries, so they
+// can share session-local state such as temporary tables or settings set
+// via `SET`.
+//
+// Create one with Client.NewSession. A Session is not safe for concurrent
+// use, since clickhouse itself serializes queries within a single session.
+type Session struct {
+	c         *Client
+	sessionID string
+}
+
+// NewSession creates a new Session bound to c, generating a fresh
+// session_id that is sent with every query issued through it.
+func (c *Client) NewSession() *Session {
+	return &Session{
+		c:         c,
+		sessionID: newQueryID(),
+	}
+}
+
+// ID returns the session_id clickhouse uses to identify this session.
+func (s *Session) ID() string {
+	return s.sessionID
+}
+
+// Do behaves like Client.Do, but additionally sends the Session's
+// session_id, so the query shares state with earlier queries issued
+// through s.
+func (s *Session) Do(query string, f ReadRowsFunc) error {
+	deadline := time.Now().Add(s.c.timeout())
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	return s.DoContext(ctx, query, f)
+}
+
+// DoContext behaves like Client.DoContext, but additionally sends the
+// Session's session_id.
+func (s *Session) DoContext(ctx context.Context, query string, f ReadRowsFunc) error {
+	queryID, _ := queryIDFromContext(ctx)
+	qp := queryParams{queryID: queryID, sessionID: s.sessionID, format: TabSeparated}
+	_, err := s.c.doQuery(ctx, query, qp, readRowsFuncToRowReaderFunc(f))
+	return err
+}
+
+// DoParams behaves like Client.DoParams, but additionally sends the
+// Session's session_id.
+func (s *Session) DoParams(ctx context.Context, query string, params map[string]interface{}, f ReadRowsFunc) error {
+	paramArgs, err := collectParamArgs(query, params)
+	if err != nil {
+		return err
+	}
+	queryID, _ := queryIDFromContext(ctx)
+	qp := queryParams{queryID: queryID, sessionID: s.sessionID, format: TabSeparated, paramArgs: paramArgs}
+	_, err = s.c.doQuery(ctx, query, qp, readRowsFuncToRowReaderFunc(f))
+	return err
+}
@@ -0,0 +1,45 @@
+package chclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSessionIDPersistsAcrossCalls(t *testing.T) {
+	var sessionIDs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionIDs = append(sessionIDs, r.URL.Query().Get("session_id"))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("cannot parse server URL: %s", err)
+	}
+
+	c := &Client{Addrs: []string{u.Host}}
+	s := c.NewSession()
+	if s.ID() == "" {
+		t.Fatalf("expecting a non-empty session_id")
+	}
+
+	if err := s.DoContext(context.Background(), "SELECT 1", nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.DoContext(context.Background(), "SELECT 2", nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(sessionIDs) != 2 {
+		t.Fatalf("got %d requests; expecting 2", len(sessionIDs))
+	}
+	if sessionIDs[0] == "" || sessionIDs[0] != sessionIDs[1] {
+		t.Fatalf("expecting the same non-empty session_id across calls; got %q and %q", sessionIDs[0], sessionIDs[1])
+	}
+	if sessionIDs[0] != s.ID() {
+		t.Fatalf("got session_id=%q; expecting %q", sessionIDs[0], s.ID())
+	}
+}